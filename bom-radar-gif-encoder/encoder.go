@@ -1,72 +1,152 @@
 package bom_radar_gif_encoder
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"image/color/palette"
 	"image/draw"
-	"image/gif"
 	"image/png"
 	"io"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jlaffaye/ftp"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/abtiwary/go-bom-radar-gif/cache"
+	"github.com/abtiwary/go-bom-radar-gif/encode"
+	"github.com/abtiwary/go-bom-radar-gif/transfer"
 )
 
-type BomRadarGifEncoder struct {
-	prodID1 string
-	prodID2 string
-	gifData []byte
-	tempFilesDir string
-	client *ftp.ServerConn
-	beVerbose bool
+const ftpAddr = "ftp.bom.gov.au:21"
+
+// transparencyTTL bounds how long a cached transparency layer is trusted
+// before it's re-fetched; they change rarely but aren't truly immutable the
+// way a timestamped radar frame file is.
+const transparencyTTL = 24 * time.Hour
+
+// frameDelay is how long each radar frame is shown before advancing to the
+// next one.
+const frameDelay = 500 * time.Millisecond
+
+type BomRadarEncoder struct {
+	prodID1        string
+	prodID2        string
+	encodedData    []byte
+	tempFilesDir   string
+	client         *ftp.ServerConn
+	transferMgr    *transfer.Manager
+	fileCache      *cache.Store
+	imgEncoder     encode.Encoder
+	lastRadarTime  time.Time
+	beVerbose      bool
 	writeTempFiles bool
 }
 
-func NewBomRadarGifEncoder(prodID1, prodID2, tempFilesDir string) (*BomRadarGifEncoder, error) {
-	encoder := new(BomRadarGifEncoder)
+// radarFileTimestampLayout matches the timestamp BOM embeds in radar frame
+// file names, e.g. "IDR713.T.202007251200.png".
+const radarFileTimestampLayout = "200601021504"
+
+// radarFileTimestamp extracts and parses the timestamp component of a BOM
+// radar frame file name. It returns the zero Time if the name doesn't carry
+// a parseable timestamp.
+func radarFileTimestamp(fileName string) time.Time {
+	parts := strings.Split(fileName, ".")
+	if len(parts) < 2 {
+		return time.Time{}
+	}
+
+	ts, err := time.Parse(radarFileTimestampLayout, parts[len(parts)-2])
+	if err != nil {
+		return time.Time{}
+	}
+	return ts
+}
+
+// NewBomRadarEncoder returns an encoder that builds the radar animation for
+// prodID1/prodID2, encoding it with imgEncoder (e.g. encode.NewGIFEncoder()
+// or encode.NewAPNGEncoder()).
+func NewBomRadarEncoder(prodID1, prodID2, tempFilesDir string, imgEncoder encode.Encoder) (*BomRadarEncoder, error) {
+	encoder := new(BomRadarEncoder)
 
 	encoder.prodID1 = prodID1
 	encoder.prodID2 = prodID2
-	encoder.gifData = make([]byte, 0)
+	encoder.encodedData = make([]byte, 0)
 	encoder.tempFilesDir = tempFilesDir
+	encoder.imgEncoder = imgEncoder
 
-	client, err := ftp.Dial("ftp.bom.gov.au:21")
+	client, err := ftp.Dial(ftpAddr)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("could not establish ftp connection: %v", err))
 	}
 	encoder.client = client
 
+	transferMgr, err := transfer.NewManager(context.Background(), transfer.Config{
+		Addr:     ftpAddr,
+		User:     "anonymous",
+		Password: "guest",
+	})
+	if err != nil {
+		client.Quit()
+		return nil, errors.New(fmt.Sprintf("could not set up the transfer manager: %v", err))
+	}
+	encoder.transferMgr = transferMgr
+
+	encoder.fileCache = cache.NewStore(filepath.Join(tempFilesDir, "cache"), 0)
+
 	encoder.beVerbose = false
 	encoder.writeTempFiles = false
 
 	return encoder, nil
 }
 
-func (enc *BomRadarGifEncoder) Close() {
+func (enc *BomRadarEncoder) Close() {
 	if enc.client != nil {
 		enc.client.Quit()
 	}
+	if enc.transferMgr != nil {
+		enc.transferMgr.Close()
+	}
 }
 
-func (enc *BomRadarGifEncoder) ToggleVerbosity() {
+func (enc *BomRadarEncoder) ToggleVerbosity() {
 	enc.beVerbose = !enc.beVerbose
 }
 
-func (enc *BomRadarGifEncoder) ToggleTempFiles() {
+// Purge drops every cached transparency layer and radar frame, in memory
+// and on disk.
+func (enc *BomRadarEncoder) Purge() {
+	enc.fileCache.Purge()
+}
+
+// CacheStats returns the running count of cache hits and misses across the
+// lifetime of the encoder.
+func (enc *BomRadarEncoder) CacheStats() (hits, misses uint64) {
+	return enc.fileCache.Stats()
+}
+
+// LastRadarTimestamp returns the timestamp embedded in the most recent
+// radar frame used by the last successful call to Make, or the zero Time
+// if Make hasn't run yet.
+func (enc *BomRadarEncoder) LastRadarTimestamp() time.Time {
+	return enc.lastRadarTime
+}
+
+func (enc *BomRadarEncoder) ToggleTempFiles() {
 	enc.writeTempFiles = !enc.writeTempFiles
 }
 
-func (enc *BomRadarGifEncoder) ListCurrentDirectory() (string, error) {
+func (enc *BomRadarEncoder) ListCurrentDirectory() (string, error) {
 	currFTPDir, err := enc.client.CurrentDir()
 	if err != nil {
 		return "", errors.New(fmt.Sprintf("could not get the current dir: %v", err))
@@ -84,7 +164,7 @@ func (enc *BomRadarGifEncoder) ListCurrentDirectory() (string, error) {
 	return currFTPDir, nil
 }
 
-func (enc *BomRadarGifEncoder) ChangeDirectory(path string) (string, error) {
+func (enc *BomRadarEncoder) ChangeDirectory(path string) (string, error) {
 	err := enc.client.ChangeDir(path)
 	if err != nil {
 		return "", errors.New(fmt.Sprintf("could not change to the new dir: %v", err))
@@ -98,74 +178,136 @@ func (enc *BomRadarGifEncoder) ChangeDirectory(path string) (string, error) {
 	return newFTPDir, nil
 }
 
+// fetchImage returns the decoded image at remotePath, serving it from the
+// file cache when possible and only reaching out to the transfer manager on
+// a cache miss. ttl bounds how long the cached bytes are trusted before
+// they're re-fetched; zero means the entry is only ever evicted by LRU
+// pressure (appropriate for the immutable, timestamped radar frame files).
+// Byte-level progress for a cache miss is reported on out under stage.
+func (enc *BomRadarEncoder) fetchImage(ctx context.Context, remotePath string, ttl time.Duration, out chan<- Progress, stage Stage) (image.Image, error) {
+	data, err := enc.fileCache.GetOrFetch(remotePath, ttl, func() ([]byte, error) {
+		resp, err := enc.transferMgr.Download(ctx, remotePath)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Close()
 
-func (enc *BomRadarGifEncoder) MakeGif() ([]byte, error) {
+		return io.ReadAll(NewProgressReader(resp, out, stage, 0))
+	})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error retrieving %s: %v", remotePath, err))
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("could not decode image data from %s: %v", remotePath, err))
+	}
+	img.ColorModel().Convert(color.RGBA{})
+
+	return img, nil
+}
+
+// fetchImagesConcurrently fetches every path in remotePaths in parallel,
+// through the file cache and transfer manager, and returns the decoded
+// images in the same order, or the first error encountered. A
+// stage-transition Progress event is emitted on out as each path finishes.
+func (enc *BomRadarEncoder) fetchImagesConcurrently(ctx context.Context, remotePaths []string, ttl time.Duration, out chan<- Progress, stage Stage) ([]image.Image, error) {
+	images := make([]image.Image, len(remotePaths))
+	errs := make([]error, len(remotePaths))
+
+	var completed int32
+	var wg sync.WaitGroup
+	for i, remotePath := range remotePaths {
+		wg.Add(1)
+		go func(i int, remotePath string) {
+			defer wg.Done()
+			images[i], errs[i] = enc.fetchImage(ctx, remotePath, ttl, out, stage)
+
+			emitProgress(out, Progress{
+				Stage:   stage,
+				Current: int(atomic.AddInt32(&completed, 1)),
+				Total:   len(remotePaths),
+				Message: remotePath,
+			})
+		}(i, remotePath)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return images, nil
+}
+
+// Make builds the radar animation and returns its encoded bytes along with
+// the Content-Type for enc's Encoder. It's a convenience wrapper around
+// MakeWithProgress for callers that don't care about progress events.
+func (enc *BomRadarEncoder) Make(ctx context.Context) ([]byte, string, error) {
+	return enc.MakeWithProgress(ctx, nil)
+}
+
+// MakeWithProgress builds the radar animation, reporting Progress events on
+// out as it moves through each stage. out may be nil, in which case
+// progress is simply not reported. ctx bounds the FTP downloads; it does
+// not interrupt image compositing or encoding once they've started.
+func (enc *BomRadarEncoder) MakeWithProgress(ctx context.Context, out chan<- Progress) ([]byte, string, error) {
 	if enc.client == nil {
-		return nil, errors.New(fmt.Sprintf("the FTP client was not initialized"))
+		return nil, "", errors.New(fmt.Sprintf("the FTP client was not initialized"))
 	}
 
+	emitProgress(out, Progress{Stage: StageLoginFTP, Current: 0, Total: 1, Message: "logging in"})
+
 	// log on
 	err := enc.client.Login("anonymous", "guest")
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("the FTP client could not log on: %v", err))
+		return nil, "", errors.New(fmt.Sprintf("the FTP client could not log on: %v", err))
 	}
 
+	emitProgress(out, Progress{Stage: StageLoginFTP, Current: 1, Total: 1, Message: "logged in"})
+
 	_, err = enc.ListCurrentDirectory()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	_, err = enc.ChangeDirectory("/")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	_, err = enc.ChangeDirectory("/anon/gen/radar_transparencies")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// the following transparencies (in the form of png files) will be composited into a base
-	// layer atop of which will sit the radar image
+	// layer atop of which will sit the radar image. they're fetched concurrently through the
+	// transfer manager, which pools FTP connections and retries transient failures on its own.
 	transparencyLayerNames := [4]string{"background", "catchments", "waterways", "locations"}
-	transparencyLayers := make([]image.Image, 0, 4)
-
-	for _, layerName := range transparencyLayerNames {
+	transparencyPaths := make([]string, len(transparencyLayerNames))
+	for i, layerName := range transparencyLayerNames {
 		layerFileName := fmt.Sprintf("%s.%s.png", enc.prodID1, layerName)
-		resp, err := enc.client.Retr(layerFileName)
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("error retriving base layer file %s: %v", layerFileName, err))
-		}
-
-		var layerBytes bytes.Buffer
-		layerByteWriter := bufio.NewWriter(&layerBytes)
-		readSize, err := io.Copy(layerByteWriter, resp)
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("error reading base layer file %s: %v", layerFileName, err))
-		}
-		fmt.Println(readSize)
-
-		layerByteReader := bytes.NewReader(layerBytes.Bytes())
-		img, _, err := image.Decode(layerByteReader)
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("could not read layer file data into image %s: %v", layerFileName, err))
-		}
-		img.ColorModel().Convert(color.RGBA{})
-
-		// close the response object to avoid getting into "extended passive mode"
-		resp.Close()
+		transparencyPaths[i] = fmt.Sprintf("/anon/gen/radar_transparencies/%s", layerFileName)
+	}
 
-		transparencyLayers = append(transparencyLayers, img)
+	transparencyLayers, err := enc.fetchImagesConcurrently(ctx, transparencyPaths, transparencyTTL, out, StageFetchTransparency)
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("error retrieving the transparency layers: %v", err))
+	}
 
-		if enc.writeTempFiles {
+	if enc.writeTempFiles {
+		for i, layerName := range transparencyLayerNames {
 			tempFile := fmt.Sprintf("%s%s_image.png", enc.tempFilesDir, layerName)
-			out, err := os.Create(tempFile)
+			tempOut, err := os.Create(tempFile)
 			if err != nil {
 				log.Warnf("error creating temp file %s: %v", tempFile, err)
 			}
-			defer out.Close()
+			defer tempOut.Close()
 
-			err = png.Encode(out, img)
+			err = png.Encode(tempOut, transparencyLayers[i])
 			log.Warnf("error writing temp file %s: %v", tempFile, err)
 		}
 	}
@@ -195,21 +337,21 @@ func (enc *BomRadarGifEncoder) MakeGif() ([]byte, error) {
 
 	_, err = enc.ChangeDirectory("/")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	_, err = enc.ChangeDirectory("/anon/gen/radar")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	names, err := enc.client.NameList("")
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("error getting the name list for dir /anon/gen/radar: %v", err))
+		return nil, "", errors.New(fmt.Sprintf("error getting the name list for dir /anon/gen/radar: %v", err))
 	}
 
 	relevantRadarFiles := make([]string, 0, 7)
-	for _, fileName := range(names) {
+	for _, fileName := range names {
 		if strings.Contains(fileName, enc.prodID2) {
 			relevantRadarFiles = append(relevantRadarFiles, fileName)
 		}
@@ -221,10 +363,10 @@ func (enc *BomRadarGifEncoder) MakeGif() ([]byte, error) {
 
 	sort.SliceStable(relevantRadarFiles, func(i, j int) bool {
 		iSplit := strings.Split(relevantRadarFiles[i], ".")
-		iTs, _ := strconv.Atoi(iSplit[len(iSplit) - 2])
+		iTs, _ := strconv.Atoi(iSplit[len(iSplit)-2])
 
 		jSplit := strings.Split(relevantRadarFiles[j], ".")
-		jTs, _ := strconv.Atoi(jSplit[len(jSplit) - 2])
+		jTs, _ := strconv.Atoi(jSplit[len(jSplit)-2])
 
 		return iTs < jTs
 	})
@@ -233,79 +375,80 @@ func (enc *BomRadarGifEncoder) MakeGif() ([]byte, error) {
 		log.Infof("relevant radar files - sorted: %v", relevantRadarFiles)
 	}
 
-	radarLoopGif := gif.GIF{LoopCount: 7}
-	for i := len(relevantRadarFiles) - 7; i < len(relevantRadarFiles); i++ {
-		resp, err := enc.client.Retr(relevantRadarFiles[i])
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("error retrieving the radar data file %s: %v", relevantRadarFiles[i], err))
-		}
-
-		var radarBytes bytes.Buffer
-		layerByteWriter := bufio.NewWriter(&radarBytes)
-		readSize, err := io.Copy(layerByteWriter, resp)
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("error reading the radar data %s: %v", relevantRadarFiles[i], err))
-		}
-
-		if enc.beVerbose {
-			log.Infof("read the following number of bytes: %v", readSize)
-		}
+	mostRecentRadarFiles := relevantRadarFiles[len(relevantRadarFiles)-7:]
+	radarFramePaths := make([]string, len(mostRecentRadarFiles))
+	for i, fileName := range mostRecentRadarFiles {
+		radarFramePaths[i] = fmt.Sprintf("/anon/gen/radar/%s", fileName)
+	}
 
-		layerByteReader := bytes.NewReader(radarBytes.Bytes())
-		img, _, err := image.Decode(layerByteReader)
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("error reading the radar data into image %s: %v", relevantRadarFiles[i], err))
-		}
-		img.ColorModel().Convert(color.RGBA{})
+	enc.lastRadarTime = radarFileTimestamp(mostRecentRadarFiles[len(mostRecentRadarFiles)-1])
 
-		// close the response object to avoid getting into "extended passive mode"
-		resp.Close()
+	radarFrames, err := enc.fetchImagesConcurrently(ctx, radarFramePaths, 0, out, StageFetchRadar)
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("error retrieving the radar data files: %v", err))
+	}
 
-		webSafePalette := palette.WebSafe
-		webSafePalette = append(webSafePalette, image.Transparent)
+	emitProgress(out, Progress{Stage: StageComposite, Current: 0, Total: len(radarFrames), Message: "compositing frames"})
 
-		combinedTemp := image.NewPaletted(combinedRGBA.Bounds(), palette.WebSafe)
+	// each composited frame keeps the full RGBA color the encoder was given;
+	// it's up to enc.imgEncoder whether (and how) to quantize it
+	frames := make([]image.Image, len(radarFrames))
+	delays := make([]time.Duration, len(radarFrames))
+	for i, img := range radarFrames {
+		combinedTemp := image.NewRGBA(combinedRGBA.Bounds())
 
 		draw.Draw(combinedTemp, backgroundLayerRect, combinedRGBA, combinedRGBA.Bounds().Min, draw.Src)
 		draw.Draw(combinedTemp, backgroundLayerRect, img, combinedRGBA.Bounds().Min, draw.Over)
 
-		// append the new "frame" to the gif
-		radarLoopGif.Image = append(radarLoopGif.Image, combinedTemp)
-		radarLoopGif.Delay = append(radarLoopGif.Delay, 50)
+		frames[i] = combinedTemp
+		delays[i] = frameDelay
+
+		emitProgress(out, Progress{Stage: StageComposite, Current: i + 1, Total: len(radarFrames)})
 	}
 
 	if enc.writeTempFiles {
 		tempFile := fmt.Sprintf("%sbase_image.png", enc.tempFilesDir)
-		out, err := os.Create(tempFile)
+		tempOut, err := os.Create(tempFile)
 		if err != nil {
 			log.Warnf("error creating temp file %s: %v", tempFile, err)
 		}
-		defer out.Close()
+		defer tempOut.Close()
 
-		err = png.Encode(out, combinedRGBA)
+		err = png.Encode(tempOut, combinedRGBA)
 		log.Warnf("error writing temp file %s: %v", tempFile, err)
+	}
 
-		tempGifFile := fmt.Sprintf("%sradar_loop.gif", enc.tempFilesDir)
-		outGif, err := os.Create(tempGifFile)
-		if err != nil {
-			log.Warnf("error creating temp file %s: %v", tempGifFile, err)
-		}
-		defer outGif.Close()
+	emitProgress(out, Progress{Stage: StageEncodeGIF, Current: 0, Total: 1, Message: "encoding"})
 
-		err = gif.EncodeAll(outGif, &radarLoopGif)
-		if err != nil {
-			log.Warnf("error writing temp gif file %s: %v", tempGifFile, err)
-		}
+	var encoded bytes.Buffer
+	if err := enc.imgEncoder.Encode(&encoded, frames, delays); err != nil {
+		return nil, "", errors.New(fmt.Sprintf("error encoding the radar animation: %v", err))
 	}
+	enc.encodedData = encoded.Bytes()
 
-	// finally update the gif bytes in the encoder
-	var gifBytes bytes.Buffer
-	encGifWriter := bufio.NewWriter(&gifBytes)
-	err = gif.EncodeAll(encGifWriter, &radarLoopGif)
-	if err != nil {
-		return nil, errors.New(fmt.Sprintf("error updating encoder's radar gif bytes: %v", err))
+	if enc.writeTempFiles {
+		tempAnimFile := fmt.Sprintf("%sradar_loop%s", enc.tempFilesDir, extensionForContentType(enc.imgEncoder.ContentType()))
+		if err := os.WriteFile(tempAnimFile, enc.encodedData, 0o644); err != nil {
+			log.Warnf("error writing temp animation file %s: %v", tempAnimFile, err)
+		}
 	}
-	enc.gifData = gifBytes.Bytes()
 
-	return enc.gifData, nil
+	emitProgress(out, Progress{Stage: StageEncodeGIF, Current: 1, Total: 1, Message: "done"})
+
+	return enc.encodedData, enc.imgEncoder.ContentType(), nil
+}
+
+// extensionForContentType maps a content type produced by an encode.Encoder
+// to the file extension used for the debug temp file.
+func extensionForContentType(contentType string) string {
+	switch contentType {
+	case "image/apng":
+		return ".png"
+	case "image/webp":
+		return ".webp"
+	case "video/mp4":
+		return ".mp4"
+	default:
+		return ".gif"
+	}
 }
@@ -0,0 +1,103 @@
+package bom_radar_gif_encoder
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// Stage identifies which phase of MakeWithProgress a Progress event
+// describes.
+type Stage string
+
+const (
+	StageLoginFTP          Stage = "LoginFTP"
+	StageFetchTransparency Stage = "FetchTransparency"
+	StageFetchRadar        Stage = "FetchRadar"
+	StageComposite         Stage = "Composite"
+	StageEncodeGIF         Stage = "EncodeGIF"
+)
+
+// Progress describes a single step, or a chunk of bytes, within a running
+// MakeWithProgress call. Current/Total count discrete steps within
+// Stage (e.g. "2 of 4 transparency layers"); BytesTransferred/TotalBytes
+// track raw bytes read for the item currently in flight. TotalBytes is 0
+// when the size isn't known ahead of time.
+type Progress struct {
+	Stage            Stage
+	Current          int
+	Total            int
+	BytesTransferred int64
+	TotalBytes       int64
+	Message          string
+}
+
+// emitProgress sends p on out without blocking: if out is nil or nobody is
+// currently receiving, the update is dropped rather than stalling the
+// transfer it's reporting on.
+func emitProgress(out chan<- Progress, p Progress) {
+	if out == nil {
+		return
+	}
+	select {
+	case out <- p:
+	default:
+	}
+}
+
+// ProgressReader wraps an io.Reader, emitting a Progress update on out as
+// bytes flow through Read. totalBytes is the expected size of the stream,
+// or 0 if unknown.
+type ProgressReader struct {
+	r                io.Reader
+	out              chan<- Progress
+	stage            Stage
+	totalBytes       int64
+	bytesTransferred int64
+}
+
+// NewProgressReader returns a ProgressReader over r that reports progress
+// for stage on out.
+func NewProgressReader(r io.Reader, out chan<- Progress, stage Stage, totalBytes int64) *ProgressReader {
+	return &ProgressReader{r: r, out: out, stage: stage, totalBytes: totalBytes}
+}
+
+func (pr *ProgressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.bytesTransferred += int64(n)
+		emitProgress(pr.out, Progress{
+			Stage:            pr.stage,
+			BytesTransferred: pr.bytesTransferred,
+			TotalBytes:       pr.totalBytes,
+		})
+	}
+	return n, err
+}
+
+// ServeProgressSSE drains events from in and writes each one to w as a
+// Server-Sent Event, flushing after every message so a browser can render
+// progress while MakeWithProgress is still running. It returns once in
+// is closed.
+func ServeProgressSSE(w http.ResponseWriter, in <-chan Progress) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, _ := w.(http.Flusher)
+
+	for p := range in {
+		data, err := json.Marshal(p)
+		if err != nil {
+			continue
+		}
+
+		w.Write([]byte("data: "))
+		w.Write(data)
+		w.Write([]byte("\n\n"))
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Package cache provides a two-tier cache for immutable (or long-lived)
+// remote files: a byte-bounded in-memory LRU backed by an on-disk store so
+// that a cold-started process doesn't have to re-fetch everything it
+// already downloaded in a previous run.
+//
+// Entries carry their own mutex so that concurrent GetOrFetch calls for the
+// same key block on each other instead of triggering a thundering herd of
+// duplicate fetches, the same trick readnetfs's CachedFile uses.
+package cache
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultMaxBytes = 256 * 1024 * 1024
+
+// entry is one cached file. Its mu serializes concurrent GetOrFetch calls
+// for this key so that only one of them ever does the actual fetch.
+type entry struct {
+	mu        sync.Mutex
+	key       string
+	data      []byte
+	size      int64
+	expiresAt time.Time // zero means the entry never expires on its own
+	elem      *list.Element
+}
+
+func (e *entry) expired() bool {
+	return !e.expiresAt.IsZero() && time.Now().After(e.expiresAt)
+}
+
+// Store is a byte-bounded in-memory LRU of entry, backed by a disk
+// directory used only to survive process restarts.
+type Store struct {
+	diskDir  string
+	maxBytes int64
+
+	mu         sync.Mutex
+	lru        *list.List
+	index      map[string]*entry
+	totalBytes int64
+
+	hits   uint64
+	misses uint64
+}
+
+// NewStore returns a Store that persists entries under diskDir and bounds
+// its in-memory footprint to maxBytes (defaultMaxBytes when zero).
+func NewStore(diskDir string, maxBytes int64) *Store {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxBytes
+	}
+
+	return &Store{
+		diskDir:  diskDir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		index:    make(map[string]*entry),
+	}
+}
+
+// GetOrFetch returns the cached bytes for key if present and unexpired,
+// otherwise it calls fetch, caches the result for ttl (zero meaning the
+// entry is only ever evicted by LRU pressure), and returns it. Concurrent
+// calls for the same key share a single fetch.
+func (s *Store) GetOrFetch(key string, ttl time.Duration, fetch func() ([]byte, error)) ([]byte, error) {
+	e := s.entryFor(key)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.data != nil && !e.expired() {
+		atomic.AddUint64(&s.hits, 1)
+		s.mu.Lock()
+		if e.elem != nil {
+			s.lru.MoveToFront(e.elem)
+		}
+		s.mu.Unlock()
+		return e.data, nil
+	}
+
+	if data, ok := s.readDisk(key, ttl); ok {
+		atomic.AddUint64(&s.hits, 1)
+		s.store(e, data, ttl)
+		return data, nil
+	}
+
+	atomic.AddUint64(&s.misses, 1)
+
+	data, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	s.store(e, data, ttl)
+	s.writeDisk(key, data)
+
+	return data, nil
+}
+
+// entryFor returns the entry for key, creating an empty one under the
+// store lock if this is the first time key has been seen.
+func (s *Store) entryFor(key string) *entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.index[key]; ok {
+		return e
+	}
+
+	e := &entry{key: key}
+	s.index[key] = e
+	return e
+}
+
+// store records data against e, moving it to the front of the LRU and
+// evicting the least recently used entries until the store is back under
+// its byte budget. Must be called with e.mu held.
+func (s *Store) store(e *entry, data []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.elem != nil {
+		s.totalBytes -= e.size
+		s.lru.Remove(e.elem)
+	}
+
+	e.data = data
+	e.size = int64(len(data))
+	if ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	} else {
+		e.expiresAt = time.Time{}
+	}
+	e.elem = s.lru.PushFront(e)
+	s.totalBytes += e.size
+
+	// a concurrent Purge may have dropped e from the index between
+	// entryFor handing it out and this store() call completing; re-register
+	// it so the entry stays reachable by key instead of only by LRU pointer.
+	if s.index[e.key] != e {
+		s.index[e.key] = e
+	}
+
+	for s.totalBytes > s.maxBytes {
+		oldest := s.lru.Back()
+		if oldest == nil {
+			break
+		}
+		evicted := oldest.Value.(*entry)
+		if evicted == e {
+			// don't evict the entry we just inserted if it alone exceeds the budget
+			break
+		}
+		s.lru.Remove(oldest)
+		s.totalBytes -= evicted.size
+		delete(s.index, evicted.key)
+		evicted.elem = nil
+		evicted.data = nil
+	}
+}
+
+// Stats returns the running hit/miss counters.
+func (s *Store) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&s.hits), atomic.LoadUint64(&s.misses)
+}
+
+// Purge drops every in-memory and on-disk entry.
+func (s *Store) Purge() {
+	s.mu.Lock()
+	s.lru = list.New()
+	s.index = make(map[string]*entry)
+	s.totalBytes = 0
+	s.mu.Unlock()
+
+	if s.diskDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(s.diskDir)
+	if err != nil {
+		return
+	}
+	for _, de := range entries {
+		if strings.HasSuffix(de.Name(), diskSuffix) {
+			os.Remove(filepath.Join(s.diskDir, de.Name()))
+		}
+	}
+}
+
+const diskSuffix = ".cache"
+
+// diskPath turns a remote key (an FTP path) into a flat file name under
+// diskDir.
+func (s *Store) diskPath(key string) string {
+	flat := strings.ReplaceAll(strings.TrimPrefix(key, "/"), "/", "_")
+	return filepath.Join(s.diskDir, flat+diskSuffix)
+}
+
+func (s *Store) readDisk(key string, ttl time.Duration) ([]byte, bool) {
+	if s.diskDir == "" {
+		return nil, false
+	}
+
+	path := s.diskPath(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if ttl > 0 && time.Since(info.ModTime()) > ttl {
+		os.Remove(path)
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (s *Store) writeDisk(key string, data []byte) {
+	if s.diskDir == "" {
+		return
+	}
+	if err := os.MkdirAll(s.diskDir, 0o755); err != nil {
+		return
+	}
+	// best-effort: a failed disk write just means a slower cold start later
+	_ = os.WriteFile(s.diskPath(key), data, 0o644)
+}
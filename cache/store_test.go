@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStoreEvictsLeastRecentlyUsedUnderBytePressure(t *testing.T) {
+	s := NewStore("", 10)
+
+	fetch := func(data string) func() ([]byte, error) {
+		return func() ([]byte, error) { return []byte(data), nil }
+	}
+
+	if _, err := s.GetOrFetch("a", 0, fetch("aaaaa")); err != nil { // 5 bytes
+		t.Fatalf("GetOrFetch(a) error: %v", err)
+	}
+	if _, err := s.GetOrFetch("b", 0, fetch("bbbbb")); err != nil { // 5 bytes, totalBytes now 10
+		t.Fatalf("GetOrFetch(b) error: %v", err)
+	}
+
+	// pushes totalBytes to 15, over the 10-byte budget, so the least
+	// recently inserted entry ("a") must be evicted to make room.
+	if _, err := s.GetOrFetch("c", 0, fetch("ccccc")); err != nil {
+		t.Fatalf("GetOrFetch(c) error: %v", err)
+	}
+
+	if _, ok := s.index["a"]; ok {
+		t.Fatalf("expected \"a\" to be evicted, but it's still indexed")
+	}
+	if _, ok := s.index["b"]; !ok {
+		t.Fatalf("expected \"b\" to survive eviction")
+	}
+	if _, ok := s.index["c"]; !ok {
+		t.Fatalf("expected \"c\" to survive eviction (it was just inserted)")
+	}
+
+	// re-fetching "a" must be a real miss, not served from a stale entry.
+	var misses int
+	if _, err := s.GetOrFetch("a", 0, func() ([]byte, error) { misses++; return []byte("aaaaa"), nil }); err != nil {
+		t.Fatalf("GetOrFetch(a) (re-fetch) error: %v", err)
+	}
+	if misses != 1 {
+		t.Fatalf("expected a real fetch for evicted key \"a\", got %d fetch calls", misses)
+	}
+}
+
+func TestStoreExpiresEntriesByTTL(t *testing.T) {
+	s := NewStore("", 0)
+
+	calls := 0
+	fetch := func() ([]byte, error) { calls++; return []byte("data"), nil }
+
+	if _, err := s.GetOrFetch("k", time.Millisecond, fetch); err != nil {
+		t.Fatalf("GetOrFetch error: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := s.GetOrFetch("k", time.Millisecond, fetch); err != nil {
+		t.Fatalf("GetOrFetch (post-expiry) error: %v", err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("expected the expired entry to trigger a second fetch, got %d calls", calls)
+	}
+}
+
+func TestStorePurgeDuringInFlightFetchLeavesEntryReachable(t *testing.T) {
+	s := NewStore("", 0)
+
+	// simulate GetOrFetch having already called entryFor (and so holding a
+	// reference to the entry) just before a concurrent Purge runs.
+	e := s.entryFor("k")
+
+	s.Purge()
+
+	s.store(e, []byte("data"), 0)
+
+	if got, ok := s.index["k"]; !ok || got != e {
+		t.Fatalf("expected store() to re-register the entry in the index after a concurrent Purge")
+	}
+
+	data, err := s.GetOrFetch("k", 0, func() ([]byte, error) {
+		return nil, fmt.Errorf("should not be called: entry should already be cached")
+	})
+	if err != nil {
+		t.Fatalf("GetOrFetch error: %v", err)
+	}
+	if string(data) != "data" {
+		t.Fatalf("GetOrFetch returned %q, want %q", data, "data")
+	}
+}
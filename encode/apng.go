@@ -0,0 +1,212 @@
+package encode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"time"
+)
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+// APNGEncoder encodes frames as an animated PNG, preserving full RGBA
+// color instead of the 216-color web-safe palette the GIF path is stuck
+// with. Each frame is encoded independently with image/png and the
+// resulting IDAT chunks are repackaged into APNG's fcTL/fdAT structure.
+type APNGEncoder struct{}
+
+// NewAPNGEncoder returns an APNGEncoder.
+func NewAPNGEncoder() *APNGEncoder {
+	return &APNGEncoder{}
+}
+
+func (e *APNGEncoder) ContentType() string {
+	return "image/apng"
+}
+
+func (e *APNGEncoder) Encode(w io.Writer, frames []image.Image, delays []time.Duration) error {
+	if len(frames) == 0 {
+		return errors.New("encode: no frames to encode")
+	}
+	if len(frames) != len(delays) {
+		return errors.New("encode: frames and delays must be the same length")
+	}
+
+	var ihdr []byte
+	frameIDATs := make([][][]byte, len(frames))
+
+	for i, frame := range frames {
+		var buf bytes.Buffer
+		// image/png picks color type 2 (no alpha) or 6 (with alpha) per
+		// image based on whether it looks opaque. Forcing every frame to
+		// report non-opaque keeps that choice (and so the bitplane layout
+		// of the IDAT/fdAT payloads that follow) consistent across frames,
+		// regardless of whether any given frame happens to be fully opaque.
+		if err := png.Encode(&buf, forceAlphaImage{frame}); err != nil {
+			return err
+		}
+
+		chunks, err := readPNGChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		for _, c := range chunks {
+			switch c.kind {
+			case "IHDR":
+				if i == 0 {
+					ihdr = c.data
+				} else if !bytes.Equal(c.data, ihdr) {
+					return fmt.Errorf("encode: frame %d has a different IHDR (color type/bit depth) than frame 0", i)
+				}
+			case "IDAT":
+				frameIDATs[i] = append(frameIDATs[i], c.data)
+			}
+		}
+	}
+
+	if _, err := w.Write(pngSignature); err != nil {
+		return err
+	}
+	if err := writePNGChunk(w, "IHDR", ihdr); err != nil {
+		return err
+	}
+	if err := writePNGChunk(w, "acTL", actlChunk(len(frames))); err != nil {
+		return err
+	}
+
+	seq := uint32(0)
+	for i, idats := range frameIDATs {
+		width, height := frameDims(frames[i])
+
+		if err := writePNGChunk(w, "fcTL", fctlChunk(seq, width, height, delays[i])); err != nil {
+			return err
+		}
+		seq++
+
+		// the first frame's IDAT chunks double as the PNG's default image
+		if i == 0 {
+			for _, d := range idats {
+				if err := writePNGChunk(w, "IDAT", d); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		for _, d := range idats {
+			if err := writePNGChunk(w, "fdAT", fdatChunk(seq, d)); err != nil {
+				return err
+			}
+			seq++
+		}
+	}
+
+	return writePNGChunk(w, "IEND", nil)
+}
+
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+// readPNGChunks walks a complete PNG byte stream and returns each chunk in
+// order, without validating CRCs (the stream was just produced by
+// image/png, so it's trusted).
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if len(data) < len(pngSignature) || !bytes.Equal(data[:len(pngSignature)], pngSignature) {
+		return nil, errors.New("encode: not a PNG stream")
+	}
+
+	var chunks []pngChunk
+	pos := len(pngSignature)
+	for pos+8 <= len(data) {
+		length := binary.BigEndian.Uint32(data[pos : pos+4])
+		kind := string(data[pos+4 : pos+8])
+
+		start := pos + 8
+		end := start + int(length)
+		if end+4 > len(data) {
+			return nil, errors.New("encode: truncated PNG chunk")
+		}
+
+		chunks = append(chunks, pngChunk{kind: kind, data: data[start:end]})
+		pos = end + 4 // skip the CRC
+	}
+
+	return chunks, nil
+}
+
+// writePNGChunk writes a length-prefixed, CRC-suffixed PNG chunk to w.
+func writePNGChunk(w io.Writer, kind string, data []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+
+	typeAndData := append([]byte(kind), data...)
+	if _, err := w.Write(typeAndData); err != nil {
+		return err
+	}
+
+	var crcBuf [4]byte
+	binary.BigEndian.PutUint32(crcBuf[:], crc32.ChecksumIEEE(typeAndData))
+	_, err := w.Write(crcBuf[:])
+	return err
+}
+
+func actlChunk(numFrames int) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(numFrames))
+	binary.BigEndian.PutUint32(buf[4:8], 0) // num_plays: loop forever
+	return buf
+}
+
+func fctlChunk(seq, width, height uint32, delay time.Duration) []byte {
+	buf := make([]byte, 26)
+	binary.BigEndian.PutUint32(buf[0:4], seq)
+	binary.BigEndian.PutUint32(buf[4:8], width)
+	binary.BigEndian.PutUint32(buf[8:12], height)
+	binary.BigEndian.PutUint32(buf[12:16], 0) // x_offset
+	binary.BigEndian.PutUint32(buf[16:20], 0) // y_offset
+	binary.BigEndian.PutUint16(buf[20:22], uint16(delay.Milliseconds()))
+	binary.BigEndian.PutUint16(buf[22:24], 1000) // delay_den
+	buf[24] = 0                                  // dispose_op: none
+	buf[25] = 0                                  // blend_op: source
+	return buf
+}
+
+func fdatChunk(seq uint32, idat []byte) []byte {
+	buf := make([]byte, 4+len(idat))
+	binary.BigEndian.PutUint32(buf[0:4], seq)
+	copy(buf[4:], idat)
+	return buf
+}
+
+func frameDims(img image.Image) (width, height uint32) {
+	b := img.Bounds()
+	return uint32(b.Dx()), uint32(b.Dy())
+}
+
+// forceAlphaImage wraps an image.Image and always reports itself as
+// non-opaque, so that image/png.Encode always picks its alpha-carrying
+// color type (6) for it rather than deciding per-image based on the
+// image's actual pixel content.
+type forceAlphaImage struct {
+	image.Image
+}
+
+func (forceAlphaImage) Opaque() bool {
+	return false
+}
+
+var _ interface {
+	image.Image
+	Opaque() bool
+} = forceAlphaImage{}
@@ -0,0 +1,140 @@
+package encode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func solidFrame(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// TestAPNGEncodeRoundTripsMixedOpacityFrames guards against the regression
+// where image/png silently picks a different color type (2 vs 6) per frame
+// based on whether that one frame happens to be fully opaque, producing an
+// APNG whose later fdAT payloads don't match the stream's single IHDR.
+func TestAPNGEncodeRoundTripsMixedOpacityFrames(t *testing.T) {
+	opaqueFrame := solidFrame(4, 4, color.RGBA{R: 255, A: 255})
+	translucentFrame := solidFrame(4, 4, color.RGBA{R: 0, G: 255, A: 255})
+	translucentFrame.SetRGBA(0, 0, color.RGBA{R: 0, G: 255, A: 128})
+
+	frames := []image.Image{opaqueFrame, translucentFrame}
+	delays := []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}
+
+	var buf bytes.Buffer
+	if err := NewAPNGEncoder().Encode(&buf, frames, delays); err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+
+	chunks, err := readPNGChunks(buf.Bytes())
+	if err != nil {
+		t.Fatalf("readPNGChunks error: %v", err)
+	}
+
+	var ihdr []byte
+	var acTLSeen bool
+	var fcTLCount, idatCount, fdATCount int
+	var firstFrameIDAT [][]byte
+	var secondFrameFDAT [][]byte
+	seenFirstFcTL := false
+
+	for _, c := range chunks {
+		switch c.kind {
+		case "IHDR":
+			ihdr = c.data
+		case "acTL":
+			acTLSeen = true
+			numFrames := binary.BigEndian.Uint32(c.data[0:4])
+			if numFrames != uint32(len(frames)) {
+				t.Fatalf("acTL num_frames = %d, want %d", numFrames, len(frames))
+			}
+		case "fcTL":
+			fcTLCount++
+			if fcTLCount == 1 {
+				seenFirstFcTL = true
+			}
+		case "IDAT":
+			idatCount++
+			firstFrameIDAT = append(firstFrameIDAT, c.data)
+		case "fdAT":
+			fdATCount++
+			secondFrameFDAT = append(secondFrameFDAT, c.data[4:]) // strip sequence_number
+		}
+	}
+
+	if ihdr == nil {
+		t.Fatal("no IHDR chunk found")
+	}
+	if !acTLSeen {
+		t.Fatal("no acTL chunk found")
+	}
+	if !seenFirstFcTL {
+		t.Fatal("no fcTL chunk found")
+	}
+	if fcTLCount != len(frames) {
+		t.Fatalf("fcTL count = %d, want %d", fcTLCount, len(frames))
+	}
+	if idatCount == 0 {
+		t.Fatal("expected at least one IDAT chunk for the default (first) frame")
+	}
+	if fdATCount == 0 {
+		t.Fatal("expected at least one fdAT chunk for the second frame")
+	}
+
+	colorType := ihdr[9]
+	bitDepth := ihdr[8]
+
+	// reassemble a standalone PNG for frame 0 (IHDR + its IDATs) and decode
+	// it, to confirm the default image is a valid, decodable PNG.
+	firstFramePNG := standalonePNG(ihdr, firstFrameIDAT)
+	decodedFirst, err := png.Decode(bytes.NewReader(firstFramePNG))
+	if err != nil {
+		t.Fatalf("decoding reassembled frame 0 PNG: %v", err)
+	}
+	if decodedFirst.Bounds() != opaqueFrame.Bounds() {
+		t.Fatalf("decoded frame 0 bounds = %v, want %v", decodedFirst.Bounds(), opaqueFrame.Bounds())
+	}
+
+	// reassemble frame 1 the same way (its fdAT payloads, minus the
+	// sequence number prefix, are bit-for-bit IDAT payloads) and confirm it
+	// decodes too - this is exactly the case that was corrupt before the
+	// fix, since frame 1 has a translucent pixel and frame 0 doesn't.
+	secondFramePNG := standalonePNG(ihdr, secondFrameFDAT)
+	decodedSecond, err := png.Decode(bytes.NewReader(secondFramePNG))
+	if err != nil {
+		t.Fatalf("decoding reassembled frame 1 PNG (color type %d, bit depth %d): %v", colorType, bitDepth, err)
+	}
+	if decodedSecond.Bounds() != translucentFrame.Bounds() {
+		t.Fatalf("decoded frame 1 bounds = %v, want %v", decodedSecond.Bounds(), translucentFrame.Bounds())
+	}
+
+	r, g, b, a := decodedSecond.At(0, 0).RGBA()
+	if a == 0xffff {
+		t.Fatalf("expected the translucent pixel to round-trip with alpha < 0xffff, got rgba=(%d,%d,%d,%d)", r, g, b, a)
+	}
+}
+
+// standalonePNG reassembles a valid single-image PNG byte stream out of a
+// shared IHDR and a frame's own IDAT (or de-prefixed fdAT) payloads, so the
+// frame can be decoded and checked independently of the APNG container.
+func standalonePNG(ihdr []byte, idats [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	writePNGChunk(&buf, "IHDR", ihdr)
+	for _, d := range idats {
+		writePNGChunk(&buf, "IDAT", d)
+	}
+	writePNGChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
@@ -0,0 +1,45 @@
+// Package encode provides pluggable animation encoders for the composited
+// radar frames produced by the bom-radar-gif-encoder package, so a caller
+// can trade the paletted image/gif output (which badly quantizes BOM's
+// rainfall intensity gradients) for a format that preserves full color.
+package encode
+
+import (
+	"image"
+	"io"
+	"strings"
+	"time"
+)
+
+// Encoder turns a sequence of frames, each shown for its corresponding
+// delay, into an encoded animation written to w.
+type Encoder interface {
+	// Encode writes the animation to w. frames and delays are the same
+	// length; delays[i] is how long frames[i] is displayed before the
+	// next frame (or the loop restarting, for the last frame).
+	Encode(w io.Writer, frames []image.Image, delays []time.Duration) error
+
+	// ContentType is the MIME type Encode produces, used for the HTTP
+	// Content-Type header and for Accept-header negotiation.
+	ContentType() string
+}
+
+// ForAccept picks an Encoder for the given Accept header value, preferring
+// the first supported media type the client lists. It falls back to GIF
+// when accept is empty or names nothing this package supports.
+func ForAccept(accept string) Encoder {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		switch mediaType {
+		case "video/mp4":
+			return NewMP4Encoder()
+		case "image/webp":
+			return NewWebPEncoder()
+		case "image/apng":
+			return NewAPNGEncoder()
+		case "image/gif":
+			return NewGIFEncoder()
+		}
+	}
+	return NewGIFEncoder()
+}
@@ -0,0 +1,95 @@
+package encode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// FFmpegPath is the ffmpeg binary WebPEncoder and MP4Encoder shell out to.
+// Override it to point at a specific build, or to swap in a pure-Go
+// alternative that speaks the same CLI.
+var FFmpegPath = "ffmpeg"
+
+// runFFmpegOnFrames writes frames to a temporary directory as numbered
+// PNGs and runs ffmpeg over them at fps, appending encodeArgs before an
+// output path with the given extension. It returns the encoded file.
+func runFFmpegOnFrames(ctx context.Context, frames []image.Image, fps float64, outExt string, encodeArgs []string) ([]byte, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("encode: no frames to encode")
+	}
+
+	dir, err := os.MkdirTemp("", "bom-radar-frames-*")
+	if err != nil {
+		return nil, fmt.Errorf("encode: could not create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, frame := range frames {
+		path := filepath.Join(dir, fmt.Sprintf("frame-%04d.png", i))
+		if err := writeFramePNG(path, frame); err != nil {
+			return nil, err
+		}
+	}
+
+	outPath := filepath.Join(dir, "out."+outExt)
+
+	args := []string{
+		"-y",
+		"-framerate", fmt.Sprintf("%.3f", fps),
+		"-i", filepath.Join(dir, "frame-%04d.png"),
+	}
+	args = append(args, encodeArgs...)
+	args = append(args, outPath)
+
+	cmd := exec.CommandContext(ctx, FFmpegPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("encode: ffmpeg failed: %w: %s", err, stderr.String())
+	}
+
+	return os.ReadFile(outPath)
+}
+
+func writeFramePNG(path string, frame image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("encode: could not create frame file: %w", err)
+	}
+	defer f.Close()
+
+	if err := png.Encode(f, frame); err != nil {
+		return fmt.Errorf("encode: could not write frame file: %w", err)
+	}
+	return nil
+}
+
+// fps derives a frame rate from the average of a set of per-frame delays,
+// falling back to 2fps (this module's long-standing default of a 500ms
+// delay) if delays is empty or degenerate.
+func fps(delays []time.Duration) float64 {
+	const fallback = 2.0
+
+	if len(delays) == 0 {
+		return fallback
+	}
+
+	var total time.Duration
+	for _, d := range delays {
+		total += d
+	}
+
+	avg := total / time.Duration(len(delays))
+	if avg <= 0 {
+		return fallback
+	}
+
+	return float64(time.Second) / float64(avg)
+}
@@ -0,0 +1,47 @@
+package encode
+
+import (
+	"errors"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+)
+
+// gifTick is the unit image/gif.GIF.Delay is expressed in.
+const gifTick = 10 * time.Millisecond
+
+// GIFEncoder encodes frames as a paletted, looping image/gif. It's the
+// original encoding this module produced, kept as the default.
+type GIFEncoder struct{}
+
+// NewGIFEncoder returns a GIFEncoder.
+func NewGIFEncoder() *GIFEncoder {
+	return &GIFEncoder{}
+}
+
+func (e *GIFEncoder) ContentType() string {
+	return "image/gif"
+}
+
+func (e *GIFEncoder) Encode(w io.Writer, frames []image.Image, delays []time.Duration) error {
+	if len(frames) == 0 {
+		return errors.New("encode: no frames to encode")
+	}
+	if len(frames) != len(delays) {
+		return errors.New("encode: frames and delays must be the same length")
+	}
+
+	g := &gif.GIF{LoopCount: len(frames)}
+	for i, frame := range frames {
+		paletted := image.NewPaletted(frame.Bounds(), palette.WebSafe)
+		draw.Draw(paletted, frame.Bounds(), frame, frame.Bounds().Min, draw.Src)
+
+		g.Image = append(g.Image, paletted)
+		g.Delay = append(g.Delay, int(delays[i]/gifTick))
+	}
+
+	return gif.EncodeAll(w, g)
+}
@@ -0,0 +1,34 @@
+package encode
+
+import (
+	"context"
+	"image"
+	"io"
+	"time"
+)
+
+// MP4Encoder produces an H.264 MP4 by shelling out to ffmpeg.
+type MP4Encoder struct{}
+
+// NewMP4Encoder returns an MP4Encoder.
+func NewMP4Encoder() *MP4Encoder {
+	return &MP4Encoder{}
+}
+
+func (e *MP4Encoder) ContentType() string {
+	return "video/mp4"
+}
+
+func (e *MP4Encoder) Encode(w io.Writer, frames []image.Image, delays []time.Duration) error {
+	data, err := runFFmpegOnFrames(context.Background(), frames, fps(delays), "mp4", []string{
+		"-vcodec", "libx264",
+		"-pix_fmt", "yuv420p",
+		"-movflags", "frag_keyframe+empty_moov",
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,37 @@
+package encode
+
+import (
+	"context"
+	"image"
+	"io"
+	"time"
+)
+
+// WebPEncoder produces an animated WebP by shelling out to ffmpeg (built
+// with libwebp support). There's no mature pure-Go animated WebP encoder,
+// so this follows the same pluggable-binary approach as MP4Encoder.
+type WebPEncoder struct{}
+
+// NewWebPEncoder returns a WebPEncoder.
+func NewWebPEncoder() *WebPEncoder {
+	return &WebPEncoder{}
+}
+
+func (e *WebPEncoder) ContentType() string {
+	return "image/webp"
+}
+
+func (e *WebPEncoder) Encode(w io.Writer, frames []image.Image, delays []time.Duration) error {
+	data, err := runFFmpegOnFrames(context.Background(), frames, fps(delays), "webp", []string{
+		"-vcodec", "libwebp",
+		"-lossless", "0",
+		"-quality", "80",
+		"-loop", "0",
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,140 @@
+// Package httpcache memoizes an expensive-to-build HTTP response for a
+// configurable TTL, collapsing concurrent cache misses into a single build
+// via singleflight so that a thundering herd of requests only triggers one
+// upstream rebuild.
+package httpcache
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const defaultTTL = 5 * time.Minute
+
+// BuildFunc produces the response body along with the time the underlying
+// data was last modified, used to populate Last-Modified/ETag.
+type BuildFunc func() (data []byte, lastModified time.Time, err error)
+
+type entry struct {
+	data         []byte
+	lastModified time.Time
+	etag         string
+	expiresAt    time.Time
+}
+
+// Cache memoizes the result of a BuildFunc per key for a fixed TTL.
+type Cache struct {
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]*entry
+
+	group singleflight.Group
+}
+
+// New returns a Cache whose entries live for ttl (defaultTTL when zero).
+func New(ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	return &Cache{
+		ttl:     ttl,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Handler returns an http.HandlerFunc that serves the cached response for
+// key, calling build on a miss or expiry. It honors If-None-Match and
+// If-Modified-Since, replying 304 Not Modified when appropriate.
+func (c *Cache) Handler(key, contentType string, build BuildFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, err := c.entryFor(key, build)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("ETag", e.etag)
+		w.Header().Set("Last-Modified", e.lastModified.UTC().Format(http.TimeFormat))
+
+		if notModified(r, e) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Write(e.data)
+	}
+}
+
+// entryFor returns the live entry for key, rebuilding it through build if
+// it's missing or expired. Concurrent calls for the same key share a
+// single build.
+func (c *Cache) entryFor(key string, build BuildFunc) (*entry, error) {
+	if e, ok := c.live(key); ok {
+		return e, nil
+	}
+
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		if e, ok := c.live(key); ok {
+			return e, nil
+		}
+
+		data, lastModified, err := build()
+		if err != nil {
+			return nil, err
+		}
+
+		e := &entry{
+			data:         data,
+			lastModified: lastModified,
+			etag:         fmt.Sprintf(`"%x"`, sha1.Sum(data)),
+			expiresAt:    time.Now().Add(c.ttl),
+		}
+
+		c.mu.Lock()
+		c.entries[key] = e
+		c.mu.Unlock()
+
+		return e, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*entry), nil
+}
+
+// live returns the cached entry for key if it exists and hasn't expired.
+func (c *Cache) live(key string) (*entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expiresAt) {
+		return nil, false
+	}
+	return e, true
+}
+
+// notModified reports whether r's conditional headers indicate the client
+// already has e's current representation.
+func notModified(r *http.Request, e *entry) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return inm == e.etag
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		if err == nil && !e.lastModified.After(t) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandlerServesAndReuses304OnMatchingETag(t *testing.T) {
+	var builds int32
+	build := func() ([]byte, time.Time, error) {
+		atomic.AddInt32(&builds, 1)
+		return []byte("hello"), time.Unix(1000, 0), nil
+	}
+
+	c := New(time.Minute)
+	h := c.Handler("k", "text/plain", build)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first request: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec.Body.String() != "hello" {
+		t.Fatalf("first request: body = %q, want %q", rec.Body.String(), "hello")
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag header on the first response")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("conditional request: status = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("304 response should have an empty body, got %q", rec.Body.String())
+	}
+
+	if got := atomic.LoadInt32(&builds); got != 1 {
+		t.Fatalf("build was called %d times, want 1 (both requests should share the cached entry)", got)
+	}
+}
+
+func TestHandlerHonorsIfModifiedSince(t *testing.T) {
+	lastModified := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := New(time.Minute)
+	h := c.Handler("k", "text/plain", func() ([]byte, time.Time, error) {
+		return []byte("hello"), lastModified, nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-Modified-Since", lastModified.Format(http.TimeFormat))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-Modified-Since", lastModified.Add(-time.Hour).Format(http.TimeFormat))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d for a stale If-Modified-Since", rec.Code, http.StatusOK)
+	}
+}
+
+func TestHandlerRebuildsAfterTTLExpires(t *testing.T) {
+	var builds int32
+	c := New(time.Millisecond)
+	h := c.Handler("k", "text/plain", func() ([]byte, time.Time, error) {
+		atomic.AddInt32(&builds, 1)
+		return []byte("hello"), time.Now(), nil
+	})
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	time.Sleep(5 * time.Millisecond)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := atomic.LoadInt32(&builds); got != 2 {
+		t.Fatalf("build was called %d times, want 2 (entry should have expired between requests)", got)
+	}
+}
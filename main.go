@@ -10,35 +10,95 @@ import (
 	"time"
 
 	"github.com/abtiwary/go-bom-radar-gif/bom-radar-gif-encoder"
+	"github.com/abtiwary/go-bom-radar-gif/encode"
+	"github.com/abtiwary/go-bom-radar-gif/httpcache"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// gifCacheTTL roughly matches BOM's ~6-minute radar cadence, so a cached
+// animation is very unlikely to be stale for longer than the next frame
+// takes to appear.
+const gifCacheTTL = 5 * time.Minute
+
+// animCaches holds one httpcache.Cache per negotiated content type, since
+// the cached bytes for "image/gif" and "video/mp4" aren't interchangeable
+// even though they depict the same radar frames.
+var animCaches = map[string]*httpcache.Cache{
+	"image/gif":  httpcache.New(gifCacheTTL),
+	"image/apng": httpcache.New(gifCacheTTL),
+	"image/webp": httpcache.New(gifCacheTTL),
+	"video/mp4":  httpcache.New(gifCacheTTL),
+}
+
 func initLogger() {
 	log.SetFormatter(&log.JSONFormatter{})
 	log.SetOutput(os.Stdout)
 	log.SetLevel(log.InfoLevel)
 }
 
-func getBomGif(w http.ResponseWriter, r *http.Request) {
-	bomEncoder, err := bom_radar_gif_encoder.NewBomRadarGifEncoder(
+func buildBomAnim(imgEncoder encode.Encoder) func() ([]byte, time.Time, error) {
+	return func() ([]byte, time.Time, error) {
+		bomEncoder, err := bom_radar_gif_encoder.NewBomRadarEncoder(
 			"IDR713",
 			"IDR71B",
 			"/home/pimeson/temp/",
-			)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+			imgEncoder,
+		)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		defer bomEncoder.Close()
+
+		animBytes, _, err := bomEncoder.Make(context.Background())
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		return animBytes, bomEncoder.LastRadarTimestamp(), nil
 	}
+}
 
-	defer bomEncoder.Close()
+func getBomGif(w http.ResponseWriter, r *http.Request) {
+	imgEncoder := encode.ForAccept(r.Header.Get("Accept"))
+	contentType := imgEncoder.ContentType()
+
+	animCaches[contentType].Handler("IDR713/IDR71B", contentType, buildBomAnim(imgEncoder))(w, r)
+}
 
-	gifBytes, err := bomEncoder.MakeGif()
+// getBomGifProgress streams the stages of building the animation as they
+// happen, bypassing the response cache so a client always sees a live
+// build.
+func getBomGifProgress(w http.ResponseWriter, r *http.Request) {
+	imgEncoder := encode.ForAccept(r.Header.Get("Accept"))
+
+	bomEncoder, err := bom_radar_gif_encoder.NewBomRadarEncoder(
+		"IDR713",
+		"IDR71B",
+		"/home/pimeson/temp/",
+		imgEncoder,
+	)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+	defer bomEncoder.Close()
+
+	progress := make(chan bom_radar_gif_encoder.Progress, 16)
+	done := make(chan struct{})
 
-	w.Header().Set("Content-Type", "image/gif")
-	w.Write(gifBytes)
+	go func() {
+		defer close(done)
+		bom_radar_gif_encoder.ServeProgressSSE(w, progress)
+	}()
+
+	_, _, err = bomEncoder.MakeWithProgress(r.Context(), progress)
+	close(progress)
+	<-done
+
+	if err != nil {
+		log.WithError(err).Warn("error building the animation while streaming progress")
+	}
 }
 
 func main() {
@@ -49,6 +109,7 @@ func main() {
 	}
 
 	http.HandleFunc("/", getBomGif)
+	http.HandleFunc("/progress", getBomGifProgress)
 
 	go func() {
 		err := http.ListenAndServe(":9099", nil)
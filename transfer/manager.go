@@ -0,0 +1,270 @@
+// Package transfer provides a small concurrent download manager for FTP
+// servers that are slow to respond but otherwise healthy. It maintains a
+// pool of logged-in connections, retries transient failures with
+// exponential backoff, and collapses concurrent requests for the same
+// remote path into a single in-flight fetch.
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// Config describes how a Manager should connect to the remote FTP server
+// and how aggressively it should retry transient failures.
+type Config struct {
+	Addr     string
+	User     string
+	Password string
+
+	// PoolSize is the number of concurrent FTP connections to keep open.
+	// Defaults to 4 when zero.
+	PoolSize int
+
+	// MaxAttempts is the number of times a single Download will be tried
+	// before giving up. Defaults to 5 when zero.
+	MaxAttempts int
+
+	// BaseBackoff and MaxBackoff bound the exponential backoff applied
+	// between retries. Defaults to 200ms and 3.2s when zero.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.PoolSize <= 0 {
+		c.PoolSize = 4
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 5
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = 200 * time.Millisecond
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 3200 * time.Millisecond
+	}
+	return c
+}
+
+// Manager owns a pool of *ftp.ServerConn and fetches remote files on behalf
+// of callers, retrying transient errors and deduplicating concurrent
+// requests for the same path.
+type Manager struct {
+	cfg  Config
+	pool chan *ftp.ServerConn
+
+	mu       sync.Mutex
+	inflight map[string]*call
+}
+
+// call represents a single in-flight Download for a given path. Callers
+// that ask for the same path while a call is running wait on it instead of
+// issuing a second fetch.
+type call struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// NewManager dials and logs in PoolSize connections to cfg.Addr and returns
+// a Manager backed by that pool.
+func NewManager(ctx context.Context, cfg Config) (*Manager, error) {
+	cfg = cfg.withDefaults()
+
+	pool := make(chan *ftp.ServerConn, cfg.PoolSize)
+	for i := 0; i < cfg.PoolSize; i++ {
+		conn, err := ftp.Dial(cfg.Addr, ftp.DialWithContext(ctx))
+		if err != nil {
+			drainAndQuit(pool)
+			return nil, fmt.Errorf("transfer: could not dial %s: %w", cfg.Addr, err)
+		}
+
+		if err := conn.Login(cfg.User, cfg.Password); err != nil {
+			conn.Quit()
+			drainAndQuit(pool)
+			return nil, fmt.Errorf("transfer: could not log in to %s: %w", cfg.Addr, err)
+		}
+
+		pool <- conn
+	}
+
+	return &Manager{
+		cfg:      cfg,
+		pool:     pool,
+		inflight: make(map[string]*call),
+	}, nil
+}
+
+func drainAndQuit(pool chan *ftp.ServerConn) {
+	close(pool)
+	for conn := range pool {
+		conn.Quit()
+	}
+}
+
+// Close logs out every pooled connection. It must not be called while a
+// Download is still in flight.
+func (m *Manager) Close() error {
+	close(m.pool)
+	var firstErr error
+	for conn := range m.pool {
+		if err := conn.Quit(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Download fetches remotePath, retrying transient errors with exponential
+// backoff and jitter. Concurrent calls for the same remotePath share a
+// single fetch. The returned ReadCloser is backed by an in-memory buffer;
+// the underlying pooled connection is released before Download returns.
+func (m *Manager) Download(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	if existing, ok := m.inflight[remotePath]; ok {
+		m.mu.Unlock()
+		select {
+		case <-existing.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if existing.err != nil {
+			return nil, existing.err
+		}
+		return io.NopCloser(bytes.NewReader(existing.data)), nil
+	}
+
+	c := &call{done: make(chan struct{})}
+	m.inflight[remotePath] = c
+	m.mu.Unlock()
+
+	c.data, c.err = m.fetch(ctx, remotePath)
+	close(c.done)
+
+	m.mu.Lock()
+	delete(m.inflight, remotePath)
+	m.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	return io.NopCloser(bytes.NewReader(c.data)), nil
+}
+
+func (m *Manager) fetch(ctx context.Context, remotePath string) ([]byte, error) {
+	var lastErr error
+
+	for attempt := 0; attempt < m.cfg.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			if err := sleepWithJitter(ctx, backoffFor(attempt, m.cfg.BaseBackoff, m.cfg.MaxBackoff)); err != nil {
+				return nil, err
+			}
+		}
+
+		conn, err := m.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := retrieve(conn, remotePath)
+		m.release(conn)
+
+		if err == nil {
+			return data, nil
+		}
+
+		lastErr = err
+		if !isTransient(err) {
+			return nil, fmt.Errorf("transfer: error retrieving %s: %w", remotePath, err)
+		}
+	}
+
+	return nil, fmt.Errorf("transfer: %s: giving up after %d attempts: %w", remotePath, m.cfg.MaxAttempts, lastErr)
+}
+
+func (m *Manager) acquire(ctx context.Context) (*ftp.ServerConn, error) {
+	select {
+	case conn := <-m.pool:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m *Manager) release(conn *ftp.ServerConn) {
+	m.pool <- conn
+}
+
+// retrieve reads remotePath into memory over conn and closes the response,
+// following the existing convention of the encoder package (closing the
+// response promptly avoids getting stuck in extended passive mode).
+func retrieve(conn *ftp.ServerConn, remotePath string) ([]byte, error) {
+	resp, err := conn.Retr(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	data, err := io.ReadAll(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// isTransient reports whether err is worth retrying: a dropped connection,
+// a timeout, or one of the FTP status codes the spec reserves for
+// "try again shortly" conditions.
+func isTransient(err error) bool {
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		switch protoErr.Code {
+		case ftp.StatusNotAvailable, ftp.StatusCanNotOpenDataConnection, ftp.StatusTransfertAborted:
+			return true
+		}
+	}
+
+	return false
+}
+
+func backoffFor(attempt int, base, max time.Duration) time.Duration {
+	d := base << (attempt - 1)
+	if d > max || d <= 0 {
+		d = max
+	}
+	jitter := time.Duration(rand.Int63n(int64(d) + 1))
+	return d/2 + jitter/2
+}
+
+func sleepWithJitter(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
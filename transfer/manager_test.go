@@ -0,0 +1,76 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"eof", io.EOF, true},
+		{"not available", &textproto.Error{Code: ftp.StatusNotAvailable, Msg: "busy"}, true},
+		{"can not open data connection", &textproto.Error{Code: ftp.StatusCanNotOpenDataConnection, Msg: "busy"}, true},
+		{"transfer aborted", &textproto.Error{Code: ftp.StatusTransfertAborted, Msg: "aborted"}, true},
+		{"permanent failure", &textproto.Error{Code: ftp.StatusNotLoggedIn, Msg: "denied"}, false},
+		{"unrelated error", errors.New("boom"), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isTransient(c.err); got != c.want {
+				t.Errorf("isTransient(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestBackoffForIsBoundedAndGrows(t *testing.T) {
+	base := 200 * time.Millisecond
+	max := 3200 * time.Millisecond
+
+	var prevCeiling time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		d := backoffFor(attempt, base, max)
+		if d < 0 || d > max {
+			t.Fatalf("attempt %d: backoffFor = %v, want within [0, %v]", attempt, d, max)
+		}
+
+		ceiling := base << (attempt - 1)
+		if ceiling > max || ceiling <= 0 {
+			ceiling = max
+		}
+		if d < ceiling/2 || d > ceiling {
+			t.Fatalf("attempt %d: backoffFor = %v, want within [%v, %v]", attempt, d, ceiling/2, ceiling)
+		}
+		if ceiling < prevCeiling {
+			t.Fatalf("attempt %d: backoff ceiling %v should not shrink from %v", attempt, ceiling, prevCeiling)
+		}
+		prevCeiling = ceiling
+	}
+}
+
+func TestDownloadDedupRespectsCallerContext(t *testing.T) {
+	m := &Manager{inflight: make(map[string]*call)}
+
+	blocked := &call{done: make(chan struct{})}
+	m.inflight["/some/path"] = blocked
+	defer close(blocked.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := m.Download(ctx, "/some/path")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Download with cancelled ctx while joining an in-flight call = %v, want context.Canceled", err)
+	}
+}